@@ -0,0 +1,140 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff implements exponential backoff with full jitter, following the AWS
+// "Exponential Backoff and Jitter" recommendations: delay(n) is
+// min(Cap, Base*Factor^n), optionally scaled by a uniform random in [0,1).
+//
+// DurationForAttempt is pure and safe for concurrent use by callers that
+// track their own attempt counter. Duration and Reset share mutable state
+// (an internal attempt counter) and must not be called concurrently with
+// each other.
+type Backoff struct {
+	Base     time.Duration
+	Cap      time.Duration
+	Factor   float64
+	NoJitter bool
+
+	mu         sync.Mutex
+	attempt    int
+	tickerStop chan struct{}
+}
+
+// NewBackoff returns a Backoff configured with the package defaults.
+func NewBackoff() *Backoff {
+	return &Backoff{
+		Base:   DefaultMinBackoff,
+		Cap:    DefaultMaxBackoff,
+		Factor: 2,
+	}
+}
+
+// DurationForAttempt returns the backoff delay for attempt n (the first
+// attempt is n=0), without mutating b.
+func (b *Backoff) DurationForAttempt(n int) time.Duration {
+	base := b.Base
+	if base <= 0 {
+		base = DefaultMinBackoff
+	}
+	cp := b.Cap
+	if cp <= 0 {
+		cp = DefaultMaxBackoff
+	}
+	factor := b.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	d := float64(base) * math.Pow(factor, float64(n))
+	if d > float64(cp) {
+		d = float64(cp)
+	}
+	if !b.NoJitter {
+		d *= rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Duration returns the delay for the next attempt and advances b's internal
+// attempt counter.
+func (b *Backoff) Duration() time.Duration {
+	b.mu.Lock()
+	n := b.attempt
+	b.attempt++
+	b.mu.Unlock()
+	return b.DurationForAttempt(n)
+}
+
+// Reset clears the attempt counter tracked by Duration. Callers should
+// invoke it on successful send.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	b.attempt = 0
+	b.mu.Unlock()
+}
+
+// Ticker starts a goroutine that sends the current time on the returned
+// channel after each successive backoff interval, and returns that channel.
+// Call Stop to release the underlying timer.
+func (b *Backoff) Ticker() <-chan time.Time {
+	c := make(chan time.Time)
+	stop := make(chan struct{})
+
+	b.mu.Lock()
+	if b.tickerStop != nil {
+		close(b.tickerStop)
+	}
+	b.tickerStop = stop
+	b.mu.Unlock()
+
+	go func() {
+		for n := 0; ; n++ {
+			timer := time.NewTimer(b.DurationForAttempt(n))
+			select {
+			case tm := <-timer.C:
+				select {
+				case c <- tm:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// Stop releases the goroutine started by Ticker, if any.
+func (b *Backoff) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tickerStop != nil {
+		close(b.tickerStop)
+		b.tickerStop = nil
+	}
+}
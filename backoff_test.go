@@ -0,0 +1,94 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDurationForAttemptNoJitter(t *testing.T) {
+	b := &Backoff{
+		Base:     time.Second,
+		Cap:      10 * time.Second,
+		Factor:   2,
+		NoJitter: true,
+	}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second}, // capped
+		{10, 10 * time.Second},
+	}
+	for _, c := range cases {
+		if got := b.DurationForAttempt(c.attempt); got != c.want {
+			t.Errorf("DurationForAttempt(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestBackoffDurationForAttemptJitterBounds(t *testing.T) {
+	b := &Backoff{Base: time.Second, Cap: 10 * time.Second, Factor: 2}
+	for attempt := 0; attempt < 5; attempt++ {
+		for i := 0; i < 50; i++ {
+			d := b.DurationForAttempt(attempt)
+			if d < 0 || d > b.Cap {
+				t.Fatalf("DurationForAttempt(%d) = %v, want in [0, %v]", attempt, d, b.Cap)
+			}
+		}
+	}
+}
+
+func TestBackoffDurationForAttemptDefaults(t *testing.T) {
+	var b Backoff // zero value should fall back to package defaults
+	d := b.DurationForAttempt(0)
+	if d < 0 || d > DefaultMaxBackoff {
+		t.Fatalf("zero-value Backoff.DurationForAttempt(0) = %v, want in [0, %v]", d, DefaultMaxBackoff)
+	}
+}
+
+func TestBackoffDurationAdvancesAndResets(t *testing.T) {
+	b := &Backoff{Base: time.Second, Cap: 10 * time.Second, Factor: 2, NoJitter: true}
+
+	if d := b.Duration(); d != time.Second {
+		t.Fatalf("first Duration() = %v, want %v", d, time.Second)
+	}
+	if d := b.Duration(); d != 2*time.Second {
+		t.Fatalf("second Duration() = %v, want %v", d, 2*time.Second)
+	}
+
+	b.Reset()
+	if d := b.Duration(); d != time.Second {
+		t.Fatalf("Duration() after Reset() = %v, want %v", d, time.Second)
+	}
+}
+
+func TestBackoffTicker(t *testing.T) {
+	b := &Backoff{Base: time.Millisecond, Cap: 5 * time.Millisecond, Factor: 2, NoJitter: true}
+	ticks := b.Ticker()
+	defer b.Stop()
+
+	select {
+	case <-ticks:
+	case <-time.After(time.Second):
+		t.Fatal("Ticker() did not fire within 1s")
+	}
+}
@@ -16,10 +16,9 @@
 package gcm
 
 import (
+	"fmt"
+	"sync/atomic"
 	"time"
-
-	log "github.com/Sirupsen/logrus"
-	"github.com/jpillora/backoff"
 )
 
 var (
@@ -51,106 +50,90 @@ type Notification struct {
 
 // Client is a container for http and xmpp GCM clients.
 type Client struct {
-	Debug      bool
-	senderID   string
-	apiKey     string
-	mh         MessageHandler
-	xmppClient *xmppGcmClient
-	httpClient *httpGcmClient
-	sandbox    bool
-	debug      bool
+	Debug        bool
+	senderID     string
+	apiKey       string
+	pool         *xmppPool
+	httpClient   *httpGcmClient
+	httpV1Client *httpV1GcmClient
+	sandbox      bool
+	debug        bool
+	pending      *pendingMap
+	closing      int32
 }
 
-// NewClient creates a new GCM client for this senderID.
-func NewClient(isSandbox bool, senderID string, apiKey string, h MessageHandler, debug bool) (*Client, error) {
+// NewClient creates a new GCM client for this senderID. By default it opens a
+// single xmpp connection; pass WithPoolSize to open more for throughput, and
+// WithQueueDepth to change the per-connection unacknowledged-message cap.
+func NewClient(isSandbox bool, senderID string, apiKey string, h MessageHandler, debug bool, opts ...Option) (*Client, error) {
+	o := defaultClientOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	c := &Client{
 		senderID: senderID,
 		apiKey:   apiKey,
-		mh:       h,
 		debug:    debug,
 		sandbox:  isSandbox,
+		pending:  newPendingMap(),
 	}
 
-	xm, err := connectXmpp(isSandbox, senderID, apiKey, c.onCCSMessage, debug)
+	pool, err := newXmppPool(isSandbox, senderID, apiKey, h, debug, o, c.pending)
 	if err != nil {
 		return nil, err
 	}
-	c.xmppClient = xm
+	c.pool = pool
 	c.httpClient = newHttpGcmClient(apiKey, debug)
 
-	// Ping periodically and indentify xmpp disconnect.
-	go c.monitorConnection()
-
-	log.WithField("sender id", senderID).Debug("gcm xmpp client created")
+	log().Debug("gcm xmpp client created", "sender id", senderID)
 	return c, nil
 }
 
 // Send a message using the HTTP GCM connection server.
 func (c *Client) SendHttp(m HttpMessage) (*HttpResponse, error) {
-	b := newExponentialBackoff()
-	return c.httpClient.sendHttp(m, b)
+	if c.httpClient == nil {
+		return nil, fmt.Errorf("gcm: client was created with NewClientV1, use SendV1")
+	}
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return nil, ErrClientClosing
+	}
+	id := c.pending.nextHttpID()
+	c.pending.add(id)
+	defer c.pending.remove(id)
+	return c.httpClient.sendHttp(m, NewBackoff())
 }
 
-// SendXmpp sends a message using the XMPP GCM connection server.
+// SendXmpp sends a message using the XMPP GCM connection server, dispatching
+// it to the least-loaded connection in the pool.
 func (c *Client) SendXmpp(m XmppMessage) (string, int, error) {
-	return c.xmppClient.send(m)
-}
-
-// Close will stop and close the corresponding client.
-func (c *Client) Close() error {
-	c.xmppClient.gracefulClose()
-	return nil
-}
-
-// Monitors the connection by periodic ping. When ping fails the xmpp client is replaced.
-func (c *Client) monitorConnection() {
-	for {
-		if err := c.xmppClient.pingPeriodically(DefaultPingTimeout, DefaultPingInterval); err == nil {
-			// Closed.
-			break
-		}
-		log.Debug("gcm xmpp ping timed out, creating new xmpp client")
-		if err := c.replaceXmppClient(true); err != nil {
-			log.WithField("error", err).Error("error replacing xmpp client")
-			time.Sleep(DefaultPingInterval)
-		}
+	if c.pool == nil {
+		return "", 0, fmt.Errorf("gcm: client was created with NewClientV1, which has no xmpp pool")
 	}
-}
-
-// Replaces active xmpp client and closes the old one.
-func (c *Client) replaceXmppClient(closeOld bool) error {
-	newc, err := connectXmpp(c.sandbox, c.senderID, c.apiKey, c.onCCSMessage, c.debug)
-	if err != nil {
-		log.WithField("error", err).Error("error creating xmpp client")
-		return err
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return "", 0, ErrClientClosing
 	}
-	oldc := c.xmppClient
-	c.xmppClient = newc
-	go c.monitorConnection()
-	if closeOld {
-		oldc.gracefulClose()
+	// Reserve a pendingMap slot before the potentially-blocking pool.send
+	// call (it can wait on a connection's flow-control slot), so Shutdown
+	// sees this send as in-flight even before it has a real message id.
+	provisional := c.pending.nextXmppID()
+	c.pending.add(provisional)
+	defer c.pending.remove(provisional)
+
+	id, bytes, err := c.pool.send(m)
+	if err == nil && id != "" {
+		c.pending.add(id)
 	}
-	return nil
+	return id, bytes, err
 }
 
-// CCS upstream message callback.
-// Tries to handle what it can here, before bubbling up.
-func (c *Client) onCCSMessage(cm CcsMessage) error {
-	switch {
-	case cm.MessageType == CCSNack && cm.Error == "CONNECTION_DRAINING",
-		cm.MessageType == CCSControl && cm.ControlType == "CONNECTION_DRAINING":
-		// Replace active xmpp client when server starts draining the current connection.
-		log.WithField("ccs message", cm).Warn("connection draining, replacing xmpp client")
-		if err := c.replaceXmppClient(false); err != nil {
-			log.WithField("error", err).Error("error replacing xmpp client")
-		}
-		if cm.MessageType == CCSControl {
-			// Don't bubble up, it's not a reply error.
-			return nil
-		}
+// Close will stop and close the corresponding client.
+func (c *Client) Close() error {
+	if c.pool == nil {
+		return nil
 	}
-	// Bubble up.
-	return c.mh(cm)
+	c.pool.Stop()
+	return nil
 }
 
 // Creates a new xmpp client, connects to the server and starts listening.
@@ -165,46 +148,10 @@ func connectXmpp(isSandbox bool, senderID string, apiKey string, h MessageHandle
 		if err := x.listen(h); err != nil {
 			// Pass the error upstream.
 			//c.cerr <- err
-			log.WithField("error", err).Error("gcm listen")
+			log().Error("gcm listen", "error", err)
 		}
-		log.Debug("gcm listen finished")
+		log().Debug("gcm listen finished")
 	}()
 
 	return x, nil
 }
-
-// Implementation of backoff provider using exponential backoff.
-type exponentialBackoff struct {
-	b            backoff.Backoff
-	currentDelay time.Duration
-}
-
-// Factory method for exponential backoff, uses default values for Min and Max and
-// adds Jitter.
-func newExponentialBackoff() *exponentialBackoff {
-	b := &backoff.Backoff{
-		Min:    DefaultMinBackoff,
-		Max:    DefaultMaxBackoff,
-		Jitter: true,
-	}
-	return &exponentialBackoff{b: *b, currentDelay: b.Duration()}
-}
-
-// Returns true if not over the retries limit
-func (eb exponentialBackoff) sendAnother() bool {
-	return eb.currentDelay <= eb.b.Max
-}
-
-// Set the minumim delay for backoff
-func (eb *exponentialBackoff) setMin(min time.Duration) {
-	eb.b.Min = min
-	if (eb.currentDelay) < min {
-		eb.currentDelay = min
-	}
-}
-
-// Wait for the current value of backoff
-func (eb exponentialBackoff) wait() {
-	time.Sleep(eb.currentDelay)
-	eb.currentDelay = eb.b.Duration()
-}
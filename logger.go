@@ -0,0 +1,61 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import "sync/atomic"
+
+// Logger is the structured logging interface this package logs through.
+// msg is a short, human-readable event name; keysAndValues are alternating
+// key, value pairs giving context, mirroring the convention used by zap's
+// SugaredLogger and similar libraries.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// noopLogger discards everything. It is the default Logger, so embedders who
+// don't care about this package's internal logging pay no cost for it.
+type noopLogger struct{}
+
+func (noopLogger) Debug(string, ...interface{}) {}
+func (noopLogger) Info(string, ...interface{})  {}
+func (noopLogger) Warn(string, ...interface{})  {}
+func (noopLogger) Error(string, ...interface{}) {}
+
+// loggerHolder wraps a Logger so that currentLogger always stores the same
+// concrete type, as atomic.Value requires.
+type loggerHolder struct{ l Logger }
+
+var currentLogger atomic.Value
+
+func init() {
+	currentLogger.Store(loggerHolder{noopLogger{}})
+}
+
+// SetLogger sets the Logger used for this package's internal logging
+// (connection lifecycle, reconnects, CCS control messages). It defaults to
+// a no-op logger. Call it once during startup, before creating any Client.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = noopLogger{}
+	}
+	currentLogger.Store(loggerHolder{l})
+}
+
+func log() Logger {
+	return currentLogger.Load().(loggerHolder).l
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"sync"
+)
+
+var (
+	// DefaultPoolSize is the number of xmpp connections opened by NewClient
+	// when WithPoolSize is not supplied.
+	DefaultPoolSize = 1
+	// DefaultQueueDepth is the maximum number of unacknowledged upstream
+	// messages allowed on a single xmpp connection before sends to it block.
+	// CCS caps this at 100 per connection.
+	DefaultQueueDepth = 100
+)
+
+// Option configures optional behavior on a Client created by NewClient or
+// NewClientV1.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	poolSize   int
+	queueDepth int
+}
+
+func defaultClientOptions() clientOptions {
+	return clientOptions{
+		poolSize:   DefaultPoolSize,
+		queueDepth: DefaultQueueDepth,
+	}
+}
+
+// WithPoolSize sets the number of parallel xmpp connections the client
+// maintains. GCM/FCM recommends multiple connections for throughput, since
+// each is capped at 100 unacknowledged messages.
+func WithPoolSize(n int) Option {
+	return func(o *clientOptions) { o.poolSize = n }
+}
+
+// WithQueueDepth sets the maximum number of unacknowledged upstream messages
+// allowed on a single xmpp connection before SendXmpp blocks waiting for
+// acks to drain.
+func WithQueueDepth(n int) Option {
+	return func(o *clientOptions) { o.queueDepth = n }
+}
+
+// poolMember is a single xmpp connection within an xmppPool, along with the
+// bookkeeping needed for per-connection flow control.
+type poolMember struct {
+	sm  *StreamManager
+	sem chan struct{} // one slot held per unacknowledged upstream message
+}
+
+func (pm *poolMember) outstanding() int {
+	return len(pm.sem)
+}
+
+// onCCSMessage is the per-connection CCS callback. It releases the
+// flow-control slot and pending-message tracking on any terminal reply to
+// an upstream send (ack or nack), replaces only this member on
+// CONNECTION_DRAINING, and otherwise bubbles the message up to the pool's
+// handler.
+func (pm *poolMember) onCCSMessage(h MessageHandler, pending *pendingMap, cm CcsMessage) error {
+	if cm.MessageType == CCSAck || cm.MessageType == CCSNack {
+		select {
+		case <-pm.sem:
+		default:
+		}
+		pending.remove(cm.MessageId)
+	}
+
+	switch {
+	case cm.MessageType == CCSNack && cm.Error == "CONNECTION_DRAINING",
+		cm.MessageType == CCSControl && cm.ControlType == "CONNECTION_DRAINING":
+		log().Warn("connection draining, replacing pool member", "ccs message", cm)
+		if err := pm.sm.drain(); err != nil {
+			log().Error("error replacing xmpp client", "error", err)
+		}
+		if cm.MessageType == CCSControl {
+			// Don't bubble up, it's not a reply error.
+			return nil
+		}
+	}
+	return h(cm)
+}
+
+// xmppPool manages a fixed-size set of xmpp connections (each owned by its
+// own StreamManager) and dispatches outgoing messages to the least-loaded
+// member, applying per-connection flow control.
+type xmppPool struct {
+	members []*poolMember
+
+	mu     sync.Mutex
+	rrNext int
+}
+
+func newXmppPool(isSandbox bool, senderID, apiKey string, h MessageHandler, debug bool, opts clientOptions, pending *pendingMap) (*xmppPool, error) {
+	size := opts.poolSize
+	if size < 1 {
+		size = 1
+	}
+	p := &xmppPool{}
+	for i := 0; i < size; i++ {
+		pm := &poolMember{sem: make(chan struct{}, opts.queueDepth)}
+		pm.sm = NewStreamManager(isSandbox, senderID, apiKey, func(cm CcsMessage) error {
+			return pm.onCCSMessage(h, pending, cm)
+		}, debug, nil)
+		if err := pm.sm.Start(); err != nil {
+			p.Stop()
+			return nil, err
+		}
+		p.members = append(p.members, pm)
+	}
+	return p, nil
+}
+
+// least picks the member with the fewest outstanding unacknowledged
+// messages, breaking ties round-robin.
+func (p *xmppPool) least() *poolMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := p.members[p.rrNext%len(p.members)]
+	for i := 1; i < len(p.members); i++ {
+		m := p.members[(p.rrNext+i)%len(p.members)]
+		if m.outstanding() < best.outstanding() {
+			best = m
+		}
+	}
+	p.rrNext++
+	return best
+}
+
+// send dispatches m to the least-loaded connection, blocking if that
+// connection is already at its queue depth (DefaultQueueDepth unless set
+// with WithQueueDepth) of unacknowledged messages.
+func (p *xmppPool) send(m XmppMessage) (string, int, error) {
+	pm := p.least()
+	pm.sem <- struct{}{}
+	id, bytes, err := pm.sm.activeClient().send(m)
+	if err != nil {
+		<-pm.sem
+	}
+	return id, bytes, err
+}
+
+// Stop closes every connection in the pool.
+func (p *xmppPool) Stop() {
+	for _, pm := range p.members {
+		pm.sm.Stop()
+	}
+}
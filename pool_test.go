@@ -0,0 +1,61 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import "testing"
+
+// newTestPoolMember returns a poolMember with the given number of slots
+// already occupied, suitable for exercising xmppPool.least() without a real
+// xmpp connection.
+func newTestPoolMember(queueDepth, occupied int) *poolMember {
+	pm := &poolMember{sem: make(chan struct{}, queueDepth)}
+	for i := 0; i < occupied; i++ {
+		pm.sem <- struct{}{}
+	}
+	return pm
+}
+
+func TestXmppPoolLeastPicksLeastLoaded(t *testing.T) {
+	a := newTestPoolMember(10, 5)
+	b := newTestPoolMember(10, 1)
+	c := newTestPoolMember(10, 8)
+	p := &xmppPool{members: []*poolMember{a, b, c}}
+
+	got := p.least()
+	if got != b {
+		t.Fatalf("least() picked member with %d outstanding, want the one with 1", got.outstanding())
+	}
+}
+
+func TestXmppPoolLeastRoundRobinsOnTies(t *testing.T) {
+	a := newTestPoolMember(10, 0)
+	b := newTestPoolMember(10, 0)
+	p := &xmppPool{members: []*poolMember{a, b}}
+
+	first := p.least()
+	second := p.least()
+	if first == second {
+		t.Fatalf("least() picked the same member twice in a row for equally-loaded members")
+	}
+}
+
+func TestXmppPoolLeastSingleMember(t *testing.T) {
+	a := newTestPoolMember(10, 3)
+	p := &xmppPool{members: []*poolMember{a}}
+
+	if got := p.least(); got != a {
+		t.Fatalf("least() with one member = %v, want %v", got, a)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClientClosing is returned by SendXmpp and SendHttp once Shutdown has
+// been called.
+var ErrClientClosing = errors.New("gcm: client is shutting down")
+
+// shutdownPollInterval is how often Shutdown rechecks InFlight while waiting
+// for outstanding messages to drain.
+var shutdownPollInterval = 100 * time.Millisecond
+
+// pendingMap tracks the message ids of upstream sends that have not yet been
+// acknowledged (xmpp) or completed (http), so Shutdown can wait for them to
+// drain.
+type pendingMap struct {
+	mu      sync.Mutex
+	ids     map[string]struct{}
+	httpSeq uint64
+	xmppSeq uint64
+}
+
+func newPendingMap() *pendingMap {
+	return &pendingMap{ids: make(map[string]struct{})}
+}
+
+func (p *pendingMap) add(id string) {
+	p.mu.Lock()
+	p.ids[id] = struct{}{}
+	p.mu.Unlock()
+}
+
+func (p *pendingMap) remove(id string) {
+	p.mu.Lock()
+	delete(p.ids, id)
+	p.mu.Unlock()
+}
+
+func (p *pendingMap) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.ids)
+}
+
+// nextHttpID returns a synthetic id for tracking an in-flight SendHttp call,
+// which has no CCS-assigned message id to key on.
+func (p *pendingMap) nextHttpID() string {
+	id := atomic.AddUint64(&p.httpSeq, 1)
+	return fmt.Sprintf("http-%d", id)
+}
+
+// nextXmppID returns a synthetic id for reserving a pendingMap slot for a
+// SendXmpp call before its real, CCS-assigned message id is known, so that
+// Shutdown can see it as in-flight even while it's still blocked acquiring
+// a pool connection's flow-control slot.
+func (p *pendingMap) nextXmppID() string {
+	id := atomic.AddUint64(&p.xmppSeq, 1)
+	return fmt.Sprintf("xmpp-%d", id)
+}
+
+// InFlight returns the number of upstream messages sent but not yet
+// acknowledged or completed.
+func (c *Client) InFlight() int {
+	return c.pending.len()
+}
+
+// Shutdown stops accepting new SendXmpp/SendHttp calls, waits for all
+// in-flight upstream acks and pending HTTP retries to finish or for ctx to
+// be cancelled, then closes every xmpp connection. It returns ctx.Err() if
+// the deadline is hit with messages still outstanding.
+func (c *Client) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return nil
+	}
+
+	for c.pending.len() > 0 {
+		select {
+		case <-ctx.Done():
+			log().Warn("gcm shutdown deadline exceeded with messages still in flight", "in_flight", c.pending.len())
+			if c.pool != nil {
+				c.pool.Stop()
+			}
+			return ctx.Err()
+		case <-time.After(shutdownPollInterval):
+		}
+	}
+
+	if c.pool != nil {
+		c.pool.Stop()
+	}
+	return nil
+}
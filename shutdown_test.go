@@ -0,0 +1,59 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import "testing"
+
+func TestPendingMapAddRemoveLen(t *testing.T) {
+	p := newPendingMap()
+	if got := p.len(); got != 0 {
+		t.Fatalf("len() on new pendingMap = %d, want 0", got)
+	}
+
+	p.add("a")
+	p.add("b")
+	if got := p.len(); got != 2 {
+		t.Fatalf("len() after two adds = %d, want 2", got)
+	}
+
+	p.add("a") // duplicate add must not double-count
+	if got := p.len(); got != 2 {
+		t.Fatalf("len() after duplicate add = %d, want 2", got)
+	}
+
+	p.remove("a")
+	if got := p.len(); got != 1 {
+		t.Fatalf("len() after remove = %d, want 1", got)
+	}
+
+	p.remove("does-not-exist") // must not panic or go negative
+	if got := p.len(); got != 1 {
+		t.Fatalf("len() after removing unknown id = %d, want 1", got)
+	}
+}
+
+func TestPendingMapNextIDsAreUniqueAndDistinguishable(t *testing.T) {
+	p := newPendingMap()
+	http1 := p.nextHttpID()
+	http2 := p.nextHttpID()
+	xmpp1 := p.nextXmppID()
+
+	if http1 == http2 {
+		t.Fatalf("nextHttpID() returned the same id twice: %q", http1)
+	}
+	if http1 == xmpp1 {
+		t.Fatalf("nextHttpID() and nextXmppID() collided: %q", http1)
+	}
+}
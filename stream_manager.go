@@ -0,0 +1,264 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"sync"
+	"time"
+)
+
+// ConnState describes the lifecycle state of the XMPP connection owned by a
+// StreamManager.
+type ConnState int
+
+const (
+	// Connecting indicates a connection attempt is in progress.
+	Connecting ConnState = iota
+	// Connected indicates the connection is established and listening.
+	Connected
+	// Draining indicates CCS asked this connection to drain; a replacement
+	// connection is being established and the old one kept alive until it is.
+	Draining
+	// Disconnected indicates the connection was lost and a reconnect will be
+	// attempted.
+	Disconnected
+	// Closed indicates the StreamManager was stopped and will not reconnect.
+	Closed
+)
+
+// String returns a human readable name for s.
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Draining:
+		return "draining"
+	case Disconnected:
+		return "disconnected"
+	case Closed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ReconnectPolicy decides how long to wait before the next reconnect attempt.
+// Implementations must be safe for concurrent use.
+type ReconnectPolicy interface {
+	// NextBackoff returns the delay to wait before reconnect attempt n (the
+	// first attempt after a failure is n=1).
+	NextBackoff(attempt int) time.Duration
+	// Reset is called after a successful connection, so the policy can forget
+	// about previous failed attempts.
+	Reset()
+}
+
+// defaultReconnectPolicy adapts the package's Backoff to the ReconnectPolicy
+// interface, preserving the existing reconnect behavior.
+type defaultReconnectPolicy struct {
+	b *Backoff
+}
+
+func newDefaultReconnectPolicy() *defaultReconnectPolicy {
+	return &defaultReconnectPolicy{b: NewBackoff()}
+}
+
+func (p *defaultReconnectPolicy) NextBackoff(attempt int) time.Duration {
+	return p.b.DurationForAttempt(attempt - 1)
+}
+
+func (p *defaultReconnectPolicy) Reset() {
+	p.b.Reset()
+}
+
+// StreamManager owns the lifecycle of a single XMPP connection: connecting,
+// reconnecting after ping failures or CCS-initiated draining, and reporting
+// state transitions to subscribers so embedders can log, meter, or halt
+// reconnect storms.
+type StreamManager struct {
+	sandbox  bool
+	senderID string
+	apiKey   string
+	h        MessageHandler
+	debug    bool
+	policy   ReconnectPolicy
+
+	mu      sync.Mutex
+	client  *xmppGcmClient
+	state   ConnState
+	states  chan ConnState
+	stopCh  chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewStreamManager creates a StreamManager for senderID. If policy is nil,
+// the package's default Backoff is used.
+func NewStreamManager(isSandbox bool, senderID, apiKey string, h MessageHandler, debug bool, policy ReconnectPolicy) *StreamManager {
+	if policy == nil {
+		policy = newDefaultReconnectPolicy()
+	}
+	return &StreamManager{
+		sandbox:  isSandbox,
+		senderID: senderID,
+		apiKey:   apiKey,
+		h:        h,
+		debug:    debug,
+		policy:   policy,
+		states:   make(chan ConnState, 16),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// States returns a channel on which connection state transitions are
+// published. Sends are non-blocking; slow subscribers may miss intermediate
+// states but will always observe Closed.
+func (sm *StreamManager) States() <-chan ConnState {
+	return sm.states
+}
+
+// Start connects to CCS and begins monitoring the connection, reconnecting
+// as needed until Stop is called.
+func (sm *StreamManager) Start() error {
+	if err := sm.connect(); err != nil {
+		return err
+	}
+	sm.wg.Add(1)
+	go sm.monitor()
+	return nil
+}
+
+// Stop closes the active connection and prevents further reconnects.
+func (sm *StreamManager) Stop() {
+	sm.mu.Lock()
+	if sm.stopped {
+		sm.mu.Unlock()
+		return
+	}
+	sm.stopped = true
+	close(sm.stopCh)
+	client := sm.client
+	sm.mu.Unlock()
+
+	// Close before waiting: monitor only returns once its ping loop ends.
+	if client != nil {
+		client.gracefulClose()
+	}
+	sm.wg.Wait()
+	if last := sm.activeClient(); last != nil && last != client {
+		last.gracefulClose()
+	}
+	sm.setState(Closed)
+}
+
+// activeClient returns the currently active xmpp client.
+func (sm *StreamManager) activeClient() *xmppGcmClient {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.client
+}
+
+func (sm *StreamManager) setState(s ConnState) {
+	sm.mu.Lock()
+	sm.state = s
+	sm.mu.Unlock()
+	for {
+		select {
+		case sm.states <- s:
+			return
+		default:
+		}
+		if s != Closed {
+			log().Debug("gcm stream manager state channel full, dropping update", "state", s)
+			return
+		}
+		// Drop the oldest buffered state so that Closed is never lost.
+		select {
+		case <-sm.states:
+		default:
+		}
+	}
+}
+
+// connect establishes a new xmpp client and makes it the active one.
+func (sm *StreamManager) connect() error {
+	sm.setState(Connecting)
+	x, err := connectXmpp(sm.sandbox, sm.senderID, sm.apiKey, sm.h, sm.debug)
+	if err != nil {
+		return err
+	}
+	sm.mu.Lock()
+	sm.client = x
+	sm.mu.Unlock()
+	sm.policy.Reset()
+	sm.setState(Connected)
+	return nil
+}
+
+// monitor pings the active connection and reconnects on failure, using the
+// configured ReconnectPolicy to space out attempts.
+func (sm *StreamManager) monitor() {
+	defer sm.wg.Done()
+	attempt := 0
+	for {
+		client := sm.activeClient()
+		err := client.pingPeriodically(DefaultPingTimeout, DefaultPingInterval)
+		select {
+		case <-sm.stopCh:
+			return
+		default:
+		}
+		if err == nil {
+			if sm.activeClient() != client {
+				// Closed by drain; monitor the replacement connection.
+				continue
+			}
+			// Closed gracefully.
+			return
+		}
+
+		sm.setState(Disconnected)
+		attempt++
+		wait := sm.policy.NextBackoff(attempt)
+		log().Debug("gcm xmpp ping timed out, reconnecting", "wait", wait)
+		select {
+		case <-sm.stopCh:
+			return
+		case <-time.After(wait):
+		}
+		if err := sm.connect(); err != nil {
+			log().Error("error replacing xmpp client", "error", err)
+			continue
+		}
+		attempt = 0
+	}
+}
+
+// drain replaces the active connection in response to a CCS-initiated
+// CONNECTION_DRAINING notification, keeping the old connection alive until
+// the new one is ready.
+func (sm *StreamManager) drain() error {
+	sm.setState(Draining)
+	old := sm.activeClient()
+	if err := sm.connect(); err != nil {
+		return err
+	}
+	if old != nil {
+		old.gracefulClose()
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// DefaultV1Endpoint is the FCM HTTP v1 send endpoint, with projectID
+// substituted in via fmt.Sprintf.
+var DefaultV1Endpoint = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+
+// DefaultMaxHttpRetries bounds the number of retries SendV1 will perform
+// for a retryable error before giving up.
+var DefaultMaxHttpRetries = 5
+
+// V1Message is the envelope for the FCM HTTP v1 send API.
+type V1Message struct {
+	ValidateOnly bool          `json:"validate_only,omitempty"`
+	Message      V1MessageBody `json:"message"`
+}
+
+// V1MessageBody mirrors the v1 "message" JSON object. Exactly one of Token,
+// Topic, or Condition should be set to address the message.
+type V1MessageBody struct {
+	Name         string            `json:"name,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Notification *Notification     `json:"notification,omitempty"`
+	Android      *V1AndroidConfig  `json:"android,omitempty"`
+	Apns         *V1ApnsConfig     `json:"apns,omitempty"`
+	Webpush      *V1WebpushConfig  `json:"webpush,omitempty"`
+	Token        string            `json:"token,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+	Condition    string            `json:"condition,omitempty"`
+}
+
+// V1AndroidConfig is the Android-specific override block of a V1Message.
+type V1AndroidConfig struct {
+	CollapseKey           string            `json:"collapse_key,omitempty"`
+	Priority              string            `json:"priority,omitempty"`
+	Ttl                   string            `json:"ttl,omitempty"`
+	RestrictedPackageName string            `json:"restricted_package_name,omitempty"`
+	Data                  map[string]string `json:"data,omitempty"`
+}
+
+// V1ApnsConfig is the iOS-specific override block of a V1Message.
+type V1ApnsConfig struct {
+	Headers map[string]string      `json:"headers,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// V1WebpushConfig is the web-push-specific override block of a V1Message.
+type V1WebpushConfig struct {
+	Headers      map[string]string      `json:"headers,omitempty"`
+	Data         map[string]string      `json:"data,omitempty"`
+	Notification map[string]interface{} `json:"notification,omitempty"`
+}
+
+// v1ErrorResponse mirrors the FCM v1 error envelope, e.g.
+// {"error":{"status":"UNREGISTERED","message":"..."}}.
+type v1ErrorResponse struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// v1StatusToLegacyError maps an FCM v1 error status to the equivalent legacy
+// GCM/FCM result error code, so that a single set of result handlers works
+// for both APIs.
+var v1StatusToLegacyError = map[string]string{
+	"UNREGISTERED":           "NotRegistered",
+	"INVALID_ARGUMENT":       "InvalidParameters",
+	"SENDER_ID_MISMATCH":     "MismatchSenderId",
+	"QUOTA_EXCEEDED":         "DeviceMessageRateExceeded",
+	"UNAVAILABLE":            "Unavailable",
+	"INTERNAL":               "InternalServerError",
+	"THIRD_PARTY_AUTH_ERROR": "InvalidApnsCredential",
+}
+
+// v1RetryableStatus is the set of v1 error statuses that should be retried
+// with backoff rather than surfaced immediately.
+var v1RetryableStatus = map[string]bool{
+	"UNAVAILABLE":    true,
+	"INTERNAL":       true,
+	"QUOTA_EXCEEDED": true,
+}
+
+// DefaultV1Timeout bounds how long a single FCM v1 HTTP request may take
+// before it is treated as a retryable failure.
+var DefaultV1Timeout = 30 * time.Second
+
+// httpV1GcmClient sends messages via the FCM HTTP v1 API, authenticating
+// with an OAuth2 bearer token minted from a service account.
+type httpV1GcmClient struct {
+	projectID string
+	ts        oauth2.TokenSource
+	client    *http.Client
+	debug     bool
+}
+
+func newHttpV1GcmClient(projectID string, ts oauth2.TokenSource, debug bool) *httpV1GcmClient {
+	return &httpV1GcmClient{
+		projectID: projectID,
+		ts:        ts,
+		client:    &http.Client{Timeout: DefaultV1Timeout},
+		debug:     debug,
+	}
+}
+
+// sendV1 posts m to the FCM v1 endpoint, retrying retryable errors with b
+// up to DefaultMaxHttpRetries times.
+func (c *httpV1GcmClient) sendV1(ctx context.Context, m V1Message, b *Backoff) (*HttpResponse, error) {
+	var lastResp *HttpResponse
+	var lastErr error
+	for attempt := 0; attempt <= DefaultMaxHttpRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return lastResp, ctx.Err()
+			case <-time.After(b.DurationForAttempt(attempt - 1)):
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return lastResp, err
+		}
+		resp, retryable, err := c.doSendV1(ctx, m)
+		if err == nil {
+			b.Reset()
+			return resp, nil
+		}
+		lastResp, lastErr = resp, err
+		if !retryable {
+			return resp, err
+		}
+		log().Warn("gcm v1 send failed, retrying", "attempt", attempt, "error", err)
+	}
+	return lastResp, lastErr
+}
+
+func (c *httpV1GcmClient) doSendV1(ctx context.Context, m V1Message) (resp *HttpResponse, retryable bool, err error) {
+	token, err := c.ts.Token()
+	if err != nil {
+		return nil, true, fmt.Errorf("gcm: fetching oauth2 token: %w", err)
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, false, err
+	}
+
+	url := fmt.Sprintf(DefaultV1Endpoint, c.projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	httpResp, err := c.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if httpResp.StatusCode == http.StatusOK {
+		var ok struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(respBody, &ok); err != nil {
+			return nil, false, err
+		}
+		return &HttpResponse{
+			Success: 1,
+			Results: []Result{{MessageId: ok.Name}},
+		}, false, nil
+	}
+
+	// 5xx with a body we can't parse is still worth a retry; a 4xx we can't
+	// parse is a permanent client error (bad request, auth, routing).
+	retryable = httpResp.StatusCode >= http.StatusInternalServerError
+
+	var v1Err v1ErrorResponse
+	if err := json.Unmarshal(respBody, &v1Err); err != nil {
+		return nil, retryable, fmt.Errorf("gcm: v1 send failed with status %d", httpResp.StatusCode)
+	}
+
+	legacyCode := v1StatusToLegacyError[v1Err.Error.Status]
+	if legacyCode == "" {
+		legacyCode = "InternalServerError"
+	}
+	resp = &HttpResponse{
+		Failure: 1,
+		Results: []Result{{Error: legacyCode}},
+	}
+	return resp, v1RetryableStatus[v1Err.Error.Status], fmt.Errorf("gcm: v1 send failed: %s: %s", v1Err.Error.Status, v1Err.Error.Message)
+}
+
+// NewClientV1 creates a GCM client that sends via the FCM HTTP v1 API,
+// authenticating with creds (typically loaded via
+// golang.org/x/oauth2/google.FindDefaultCredentials). XMPP/CCS is not part
+// of the v1 API, so the returned Client only supports SendV1; SendXmpp and
+// SendHttp return an error. opts is accepted for parity with NewClient, but
+// none of the current Options (pool size, queue depth) apply to the v1
+// HTTP-only client. h is likewise accepted for signature parity with
+// NewClient but is currently unused: FCM v1 has no upstream/CCS channel for
+// this package to deliver received messages on, so h is never invoked.
+func NewClientV1(ctx context.Context, projectID string, creds *google.Credentials, h MessageHandler, opts ...Option) (*Client, error) {
+	if creds == nil {
+		return nil, fmt.Errorf("gcm: creds must not be nil")
+	}
+
+	c := &Client{
+		senderID: projectID,
+		pending:  newPendingMap(),
+	}
+	c.httpV1Client = newHttpV1GcmClient(projectID, creds.TokenSource, false)
+
+	log().Debug("gcm v1 client created", "project id", projectID)
+	return c, nil
+}
+
+// SendV1 sends a message using the FCM HTTP v1 API. NewClientV1 must have
+// been used to create c.
+func (c *Client) SendV1(ctx context.Context, m V1Message) (*HttpResponse, error) {
+	if c.httpV1Client == nil {
+		return nil, fmt.Errorf("gcm: client was not created with NewClientV1")
+	}
+	if atomic.LoadInt32(&c.closing) != 0 {
+		return nil, ErrClientClosing
+	}
+	id := c.pending.nextHttpID()
+	c.pending.add(id)
+	defer c.pending.remove(id)
+	return c.httpV1Client.sendV1(ctx, m, NewBackoff())
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+type staticTokenSource struct{ token *oauth2.Token }
+
+func (s staticTokenSource) Token() (*oauth2.Token, error) { return s.token, nil }
+
+func TestV1StatusMappingCoversAllRetryableStatuses(t *testing.T) {
+	for status := range v1RetryableStatus {
+		if _, ok := v1StatusToLegacyError[status]; !ok {
+			t.Errorf("retryable status %q has no legacy error mapping", status)
+		}
+	}
+}
+
+func TestV1StatusToLegacyError(t *testing.T) {
+	cases := map[string]string{
+		"UNREGISTERED":           "NotRegistered",
+		"INVALID_ARGUMENT":       "InvalidParameters",
+		"SENDER_ID_MISMATCH":     "MismatchSenderId",
+		"QUOTA_EXCEEDED":         "DeviceMessageRateExceeded",
+		"UNAVAILABLE":            "Unavailable",
+		"INTERNAL":               "InternalServerError",
+		"THIRD_PARTY_AUTH_ERROR": "InvalidApnsCredential",
+	}
+	for status, want := range cases {
+		if got := v1StatusToLegacyError[status]; got != want {
+			t.Errorf("v1StatusToLegacyError[%q] = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestV1RetryableStatus(t *testing.T) {
+	retryable := []string{"UNAVAILABLE", "INTERNAL", "QUOTA_EXCEEDED"}
+	for _, status := range retryable {
+		if !v1RetryableStatus[status] {
+			t.Errorf("v1RetryableStatus[%q] = false, want true", status)
+		}
+	}
+	notRetryable := []string{"UNREGISTERED", "INVALID_ARGUMENT", "SENDER_ID_MISMATCH"}
+	for _, status := range notRetryable {
+		if v1RetryableStatus[status] {
+			t.Errorf("v1RetryableStatus[%q] = true, want false", status)
+		}
+	}
+}
+
+func TestDoSendV1Success(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"projects/p/messages/123"}`)
+	}))
+	defer srv.Close()
+
+	old := DefaultV1Endpoint
+	DefaultV1Endpoint = srv.URL + "/%s"
+	defer func() { DefaultV1Endpoint = old }()
+
+	c := newHttpV1GcmClient("p", staticTokenSource{&oauth2.Token{AccessToken: "t"}}, false)
+	resp, retryable, err := c.doSendV1(context.Background(), V1Message{})
+	if err != nil {
+		t.Fatalf("doSendV1() error = %v", err)
+	}
+	if retryable {
+		t.Fatalf("doSendV1() retryable = true on success")
+	}
+	if resp.Success != 1 || len(resp.Results) != 1 || resp.Results[0].MessageId != "projects/p/messages/123" {
+		t.Fatalf("doSendV1() resp = %+v, want Success=1 with the returned message id", resp)
+	}
+}
+
+func TestDoSendV1RetryableError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"error":{"status":"UNAVAILABLE","message":"try again"}}`)
+	}))
+	defer srv.Close()
+
+	old := DefaultV1Endpoint
+	DefaultV1Endpoint = srv.URL + "/%s"
+	defer func() { DefaultV1Endpoint = old }()
+
+	c := newHttpV1GcmClient("p", staticTokenSource{&oauth2.Token{AccessToken: "t"}}, false)
+	resp, retryable, err := c.doSendV1(context.Background(), V1Message{})
+	if err == nil {
+		t.Fatal("doSendV1() error = nil, want non-nil for UNAVAILABLE")
+	}
+	if !retryable {
+		t.Fatalf("doSendV1() retryable = false, want true for UNAVAILABLE")
+	}
+	if resp.Failure != 1 || resp.Results[0].Error != "Unavailable" {
+		t.Fatalf("doSendV1() resp = %+v, want Failure=1 with legacy error Unavailable", resp)
+	}
+}
+
+func TestDoSendV1NonRetryableError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"status":"UNREGISTERED","message":"gone"}}`)
+	}))
+	defer srv.Close()
+
+	old := DefaultV1Endpoint
+	DefaultV1Endpoint = srv.URL + "/%s"
+	defer func() { DefaultV1Endpoint = old }()
+
+	c := newHttpV1GcmClient("p", staticTokenSource{&oauth2.Token{AccessToken: "t"}}, false)
+	resp, retryable, err := c.doSendV1(context.Background(), V1Message{})
+	if err == nil {
+		t.Fatal("doSendV1() error = nil, want non-nil for UNREGISTERED")
+	}
+	if retryable {
+		t.Fatalf("doSendV1() retryable = true, want false for UNREGISTERED")
+	}
+	if resp.Results[0].Error != "NotRegistered" {
+		t.Fatalf("doSendV1() resp = %+v, want legacy error NotRegistered", resp)
+	}
+}
+
+func TestSendV1HonorsCancelledContext(t *testing.T) {
+	c := newHttpV1GcmClient("p", staticTokenSource{&oauth2.Token{AccessToken: "t"}}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.sendV1(ctx, V1Message{}, NewBackoff())
+	if err == nil {
+		t.Fatal("sendV1() with a cancelled context returned no error")
+	}
+}